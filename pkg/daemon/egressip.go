@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	kubeovnv1 "github.com/alauda/kube-ovn/pkg/apis/kubeovn/v1"
+	"github.com/alauda/kube-ovn/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+const (
+	// EgressIPSet holds the pod IPs that should be SNATed to a specific
+	// egress IP rather than MASQUERADEd.
+	EgressIPSet = "egress-ip-nat"
+)
+
+// getEgressIPRules resolves every EgressIP whose gateway node is this one
+// to the pod IPs it should SNAT on this node. Programming the actual
+// set/rule is the GatewayBackend's job.
+func (c *Controller) getEgressIPRules(protocol string) (map[string][]string, error) {
+	egressIPs, err := c.egressIPsLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list egress ips failed, %+v", err)
+		return nil, err
+	}
+
+	hostname := util.GetNodeName()
+	podIPsByEgressIP := map[string][]string{}
+	for _, egressIP := range egressIPs {
+		if egressIP.Status.ActivateGateway != hostname {
+			continue
+		}
+		if util.CheckProtocol(egressIP.Spec.EgressIP) != protocol {
+			continue
+		}
+		matchedPods, err := c.matchEgressIPPods(egressIP, protocol)
+		if err != nil {
+			klog.Errorf("match pods for egress ip %s failed, %+v", egressIP.Name, err)
+			continue
+		}
+		podIPsByEgressIP[egressIP.Spec.EgressIP] = append(podIPsByEgressIP[egressIP.Spec.EgressIP], matchedPods...)
+	}
+	return podIPsByEgressIP, nil
+}
+
+// matchEgressIPPods resolves an EgressIP's subnet or pod-selector target to
+// the concrete pod IPs on this node that should be SNATed, analogous to
+// getLocalPodIPsNeedNAT's node-local filtering.
+func (c *Controller) matchEgressIPPods(egressIP *kubeovnv1.EgressIP, protocol string) ([]string, error) {
+	hostname := util.GetNodeName()
+	var matched []string
+
+	allPods, err := c.podsLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	selector := labels.Everything()
+	if egressIP.Spec.PodSelector != nil {
+		s, err := metav1.LabelSelectorAsSelector(egressIP.Spec.PodSelector)
+		if err != nil {
+			return nil, err
+		}
+		selector = s
+	}
+	for _, pod := range allPods {
+		if pod.Spec.HostNetwork || pod.Status.PodIP == "" || pod.Spec.NodeName != hostname {
+			continue
+		}
+		if util.CheckProtocol(pod.Status.PodIP) != protocol {
+			continue
+		}
+		if egressIP.Spec.Subnet != "" && pod.Annotations[util.LogicalSwitchAnnotation] != egressIP.Spec.Subnet {
+			continue
+		}
+		if !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		matched = append(matched, pod.Status.PodIP)
+	}
+	return matched, nil
+}
@@ -3,7 +3,6 @@ package daemon
 import (
 	kubeovnv1 "github.com/alauda/kube-ovn/pkg/apis/kubeovn/v1"
 	"github.com/alauda/kube-ovn/pkg/util"
-	"github.com/projectcalico/felix/ipsets"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/klog"
 	"net"
@@ -18,6 +17,17 @@ const (
 	IPSetPrefix  = "ovn"
 )
 
+// ipSetNamePrefix returns the protocol-specific prefix the ipset layer
+// applies to every set name, e.g. SetID "subnets" becomes ipset
+// "ovn40subnets" for IPv4, matching the literal prefixes in
+// podNatV4Rule/podNatV6Rule below.
+func ipSetNamePrefix(protocol string) string {
+	if protocol == kubeovnv1.ProtocolIPv6 {
+		return IPSetPrefix + "60"
+	}
+	return IPSetPrefix + "40"
+}
+
 var (
 	podNatV4Rule = util.IPTableRule{
 		Table: "nat",
@@ -51,6 +61,9 @@ var (
 	}
 )
 
+// runGateway reconciles the subnets/subnets-nat/local-pod-ip-nat sets, the
+// MASQUERADE/FORWARD rules and the per-EgressIP SNAT rules on the node,
+// delegating the actual programming to a GatewayBackend.
 func (c *Controller) runGateway() {
 	subnets, err := c.getSubnetsCIDR(c.protocol)
 	if err != nil {
@@ -67,44 +80,16 @@ func (c *Controller) runGateway() {
 		klog.Errorf("get need nat subnets failed, %+v", err)
 		return
 	}
-	c.ipset.AddOrReplaceIPSet(ipsets.IPSetMetadata{
-		MaxSize: 1048576,
-		SetID:   SubnetSet,
-		Type:    ipsets.IPSetTypeHashNet,
-	}, subnets)
-	c.ipset.AddOrReplaceIPSet(ipsets.IPSetMetadata{
-		MaxSize: 1048576,
-		SetID:   LocalPodSet,
-		Type:    ipsets.IPSetTypeHashIP,
-	}, localPodIPs)
-	c.ipset.AddOrReplaceIPSet(ipsets.IPSetMetadata{
-		MaxSize: 1048576,
-		SetID:   SubnetNatSet,
-		Type:    ipsets.IPSetTypeHashNet,
-	}, subnetsNeedNat)
-	c.ipset.ApplyUpdates()
+	egressIPRules, err := c.getEgressIPRules(c.protocol)
+	if err != nil {
+		klog.Errorf("get egress ip rules failed, %+v", err)
+		return
+	}
 
-	var podNatRule, subnetNatRule util.IPTableRule
-	if c.protocol == kubeovnv1.ProtocolIPv4 {
-		podNatRule = podNatV4Rule
-		subnetNatRule = subnetNatV4Rule
-	} else {
-		podNatRule = podNatV6Rule
-		subnetNatRule = subnetNatV6Rule
-	}
-	for _, iptRule := range []util.IPTableRule{forwardAcceptRule1, forwardAcceptRule2, podNatRule, subnetNatRule} {
-		exists, err := c.iptable.Exists(iptRule.Table, iptRule.Chain, iptRule.Rule...)
-		if err != nil {
-			klog.Errorf("check iptable rule exist failed, %+v", err)
-			return
-		}
-		if !exists {
-			klog.Info("iptables rules not exist, recreate iptables rules")
-			if err := c.iptable.Insert(iptRule.Table, iptRule.Chain, 1, iptRule.Rule...); err != nil {
-				klog.Errorf("insert iptable rule %v failed, %+v", iptRule.Rule, err)
-				return
-			}
-		}
+	backend := c.ensureGatewayBackend()
+	if err := backend.SyncRules(subnets, localPodIPs, subnetsNeedNat, egressIPRules, c.protocol); err != nil {
+		klog.Errorf("sync gateway rules via %s backend failed, %+v", backend.Name(), err)
+		return
 	}
 }
 
@@ -0,0 +1,68 @@
+package daemon
+
+import (
+	"fmt"
+	"os/exec"
+
+	"k8s.io/klog"
+)
+
+const (
+	GatewayBackendIPTables = "iptables"
+	GatewayBackendNftables = "nftables"
+	GatewayBackendAuto     = "auto"
+)
+
+// GatewayBackend programs the subnets/subnets-nat/local-pod-ip-nat sets and
+// the MASQUERADE/FORWARD/EgressIP-SNAT rules runGateway needs, independent
+// of whether the underlying mechanism is iptables+ipset or nftables.
+type GatewayBackend interface {
+	// Name identifies the backend for logging.
+	Name() string
+	// SyncRules reconciles the sets and rules for protocol so that
+	// subnets, localPodIPs and subnetsNeedNat match the kernel state, and
+	// programs per-EgressIP SNAT for egressIPRules ahead of the blanket
+	// MASQUERADE rules.
+	SyncRules(subnets, localPodIPs, subnetsNeedNat []string, egressIPRules map[string][]string, protocol string) error
+}
+
+// ensureGatewayBackend lazily picks and caches the GatewayBackend to use,
+// honoring c.config.GatewayBackend ("iptables", "nftables" or "auto", the
+// default). Auto-detection prefers nftables when the nft binary is present
+// and the kernel has the nf_tables family loaded, since that's the
+// direction distributions without iptables-legacy have moved; otherwise it
+// falls back to the historical iptables+ipset implementation.
+func (c *Controller) ensureGatewayBackend() GatewayBackend {
+	if c.gatewayBackend != nil {
+		return c.gatewayBackend
+	}
+
+	kind := c.config.GatewayBackend
+	if kind == "" || kind == GatewayBackendAuto {
+		kind = detectGatewayBackend()
+	}
+
+	switch kind {
+	case GatewayBackendNftables:
+		klog.Infof("using nftables gateway backend")
+		c.gatewayBackend = newNftablesBackend()
+	default:
+		klog.Infof("using iptables gateway backend")
+		c.gatewayBackend = newIPTablesBackend(c.iptable, c.ipset)
+	}
+	return c.gatewayBackend
+}
+
+func detectGatewayBackend() string {
+	if _, err := exec.LookPath("nft"); err != nil {
+		return GatewayBackendIPTables
+	}
+	if err := exec.Command("nft", "list", "tables").Run(); err != nil {
+		return GatewayBackendIPTables
+	}
+	return GatewayBackendNftables
+}
+
+func gatewayBackendRuleError(backend, op string, err error) error {
+	return fmt.Errorf("%s backend: %s: %v", backend, op, err)
+}
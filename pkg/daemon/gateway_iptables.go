@@ -0,0 +1,134 @@
+package daemon
+
+import (
+	"strings"
+
+	kubeovnv1 "github.com/alauda/kube-ovn/pkg/apis/kubeovn/v1"
+	"github.com/alauda/kube-ovn/pkg/util"
+	"github.com/projectcalico/felix/ipsets"
+	"k8s.io/klog"
+)
+
+// iptablesBackend is the historical GatewayBackend implementation, backed
+// by iptables rules and ipset sets.
+type iptablesBackend struct {
+	iptable *util.IPTables
+	ipset   *ipsets.IPSets
+
+	// egressSNATRules is the SNAT rule last inserted for each active
+	// egress IP, keyed by protocol then egress IP since SyncRules is
+	// called once per address family and the two passes must not delete
+	// each other's rules out of a shared map.
+	egressSNATRules map[string]map[string]util.IPTableRule
+}
+
+func newIPTablesBackend(iptable *util.IPTables, ipset *ipsets.IPSets) GatewayBackend {
+	return &iptablesBackend{iptable: iptable, ipset: ipset}
+}
+
+func (b *iptablesBackend) Name() string { return GatewayBackendIPTables }
+
+func (b *iptablesBackend) SyncRules(subnets, localPodIPs, subnetsNeedNat []string, egressIPRules map[string][]string, protocol string) error {
+	b.ipset.AddOrReplaceIPSet(ipsets.IPSetMetadata{
+		MaxSize: 1048576,
+		SetID:   SubnetSet,
+		Type:    ipsets.IPSetTypeHashNet,
+	}, subnets)
+	b.ipset.AddOrReplaceIPSet(ipsets.IPSetMetadata{
+		MaxSize: 1048576,
+		SetID:   LocalPodSet,
+		Type:    ipsets.IPSetTypeHashIP,
+	}, localPodIPs)
+	b.ipset.AddOrReplaceIPSet(ipsets.IPSetMetadata{
+		MaxSize: 1048576,
+		SetID:   SubnetNatSet,
+		Type:    ipsets.IPSetTypeHashNet,
+	}, subnetsNeedNat)
+	b.ipset.ApplyUpdates()
+
+	var podNatRule, subnetNatRule util.IPTableRule
+	if protocol == kubeovnv1.ProtocolIPv4 {
+		podNatRule = podNatV4Rule
+		subnetNatRule = subnetNatV4Rule
+	} else {
+		podNatRule = podNatV6Rule
+		subnetNatRule = subnetNatV6Rule
+	}
+	for _, iptRule := range []util.IPTableRule{forwardAcceptRule1, forwardAcceptRule2, podNatRule, subnetNatRule} {
+		exists, err := b.iptable.Exists(iptRule.Table, iptRule.Chain, iptRule.Rule...)
+		if err != nil {
+			return gatewayBackendRuleError(b.Name(), "check rule exists", err)
+		}
+		if !exists {
+			klog.Info("iptables rules not exist, recreate iptables rules")
+			if err := b.iptable.Insert(iptRule.Table, iptRule.Chain, 1, iptRule.Rule...); err != nil {
+				return gatewayBackendRuleError(b.Name(), "insert rule", err)
+			}
+		}
+	}
+
+	// Inserted last: iptable.Insert always puts a rule at the top of the
+	// chain, so doing this after the MASQUERADE rules above leaves
+	// EgressIP SNAT rules matched before them.
+	if err := b.syncEgressIPRules(egressIPRules, protocol); err != nil {
+		return gatewayBackendRuleError(b.Name(), "sync egress ip rules", err)
+	}
+	return nil
+}
+
+// egressSNATRule builds the SNAT rule that rewrites the source of packets
+// from set to egressIP; syncEgressIPRules builds one such rule per distinct
+// egress IP in use on this node.
+func egressSNATRule(set, egressIP string) util.IPTableRule {
+	return util.IPTableRule{
+		Table: "nat",
+		Chain: "POSTROUTING",
+		Rule:  strings.Split("-m set --match-set "+set+" src -j SNAT --to-source "+egressIP, " "),
+	}
+}
+
+// syncEgressIPRules programs the egress-ip-nat ipset(s) and SNAT rules for
+// podIPsByEgressIP, then removes the rule and ipset for any egress IP no
+// longer active on this node.
+func (b *iptablesBackend) syncEgressIPRules(podIPsByEgressIP map[string][]string, protocol string) error {
+	rules := map[string]util.IPTableRule{}
+	for egressIP, podIPs := range podIPsByEgressIP {
+		setID := EgressIPSet + "-" + util.EncodeIpsetName(egressIP)
+		b.ipset.AddOrReplaceIPSet(ipsets.IPSetMetadata{
+			MaxSize: 1048576,
+			SetID:   setID,
+			Type:    ipsets.IPSetTypeHashIP,
+		}, podIPs)
+		rules[egressIP] = egressSNATRule(ipSetNamePrefix(protocol)+setID, egressIP)
+	}
+	b.ipset.ApplyUpdates()
+
+	for _, rule := range rules {
+		exists, err := b.iptable.Exists(rule.Table, rule.Chain, rule.Rule...)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if err := b.iptable.Insert(rule.Table, rule.Chain, 1, rule.Rule...); err != nil {
+				return err
+			}
+		}
+	}
+
+	for egressIP, rule := range b.egressSNATRules[protocol] {
+		if _, stillActive := rules[egressIP]; stillActive {
+			continue
+		}
+		if err := b.iptable.Delete(rule.Table, rule.Chain, rule.Rule...); err != nil {
+			klog.Errorf("delete stale egress ip SNAT rule for %s failed, %+v", egressIP, err)
+			continue
+		}
+		setID := EgressIPSet + "-" + util.EncodeIpsetName(egressIP)
+		b.ipset.RemoveIPSet(setID)
+	}
+	if b.egressSNATRules == nil {
+		b.egressSNATRules = map[string]map[string]util.IPTableRule{}
+	}
+	b.egressSNATRules[protocol] = rules
+	return nil
+}
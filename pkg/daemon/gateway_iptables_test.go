@@ -0,0 +1,42 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+
+	kubeovnv1 "github.com/alauda/kube-ovn/pkg/apis/kubeovn/v1"
+)
+
+// TestEgressSNATRuleMatchesIPSetName guards against the match-set name in
+// the egress SNAT rule drifting from the name the ipset layer actually
+// programs in the kernel: podNatV4Rule/podNatV6Rule hardcode "ovn40"/"ovn60"
+// as the real per-protocol prefix, so the egress rule has to be built the
+// same way rather than via the bare IPSetPrefix constant.
+func TestEgressSNATRuleMatchesIPSetName(t *testing.T) {
+	cases := []struct {
+		protocol   string
+		wantPrefix string
+	}{
+		{kubeovnv1.ProtocolIPv4, "ovn40"},
+		{kubeovnv1.ProtocolIPv6, "ovn60"},
+	}
+
+	for _, c := range cases {
+		setID := EgressIPSet + "-deadbeef"
+		rule := egressSNATRule(ipSetNamePrefix(c.protocol)+setID, "1.2.3.4")
+
+		var matchSet string
+		for i, field := range rule.Rule {
+			if field == "--match-set" && i+1 < len(rule.Rule) {
+				matchSet = rule.Rule[i+1]
+			}
+		}
+		want := c.wantPrefix + setID
+		if matchSet != want {
+			t.Errorf("protocol %s: rule match-set = %q, want %q (kernel ipset name)", c.protocol, matchSet, want)
+		}
+		if !strings.HasPrefix(matchSet, c.wantPrefix) {
+			t.Errorf("protocol %s: rule match-set %q does not use the %q ipset prefix", c.protocol, matchSet, c.wantPrefix)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package daemon
+
+import (
+	"net"
+
+	kubeovnv1 "github.com/alauda/kube-ovn/pkg/apis/kubeovn/v1"
+	"github.com/alauda/kube-ovn/pkg/util"
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"k8s.io/klog"
+)
+
+const nftablesTableName = "kube-ovn-gateway"
+
+// ctStateRelatedEstablished mirrors the kernel's NF_CT_STATE_RELATED_BIT |
+// NF_CT_STATE_ESTABLISHED_BIT, used to match "-m conntrack --ctstate
+// RELATED,ESTABLISHED" via a raw ct state bitmask.
+const ctStateRelatedEstablished = 1<<1 | 1<<2
+
+// nftablesBackend expresses the same subnets/subnets-nat/local-pod-ip-nat
+// sets and podNat/subnetNat/forwardAccept rules as iptablesBackend, but
+// natively as nft sets and rules committed in a single atomic transaction.
+// It targets distributions that have dropped iptables-legacy.
+type nftablesBackend struct {
+	conn *nftables.Conn
+
+	// egressSets is the nft set last programmed for each active egress IP,
+	// keyed by address family then egress IP since SyncRules is called
+	// once per family and the two passes must not delete each other's
+	// sets out of a shared map.
+	egressSets map[nftables.TableFamily]map[string]*nftables.Set
+}
+
+func newNftablesBackend() GatewayBackend {
+	return &nftablesBackend{conn: &nftables.Conn{}}
+}
+
+func (b *nftablesBackend) Name() string { return GatewayBackendNftables }
+
+func (b *nftablesBackend) SyncRules(subnets, localPodIPs, subnetsNeedNat []string, egressIPRules map[string][]string, protocol string) error {
+	table := b.conn.AddTable(&nftables.Table{
+		Name:   nftablesTableName,
+		Family: nftablesFamily(protocol),
+	})
+
+	subnetsSet, err := b.syncSet(table, SubnetSet, subnets, false)
+	if err != nil {
+		return gatewayBackendRuleError(b.Name(), "sync subnets set", err)
+	}
+	localPodSet, err := b.syncSet(table, LocalPodSet, localPodIPs, true)
+	if err != nil {
+		return gatewayBackendRuleError(b.Name(), "sync local pod set", err)
+	}
+	subnetsNatSet, err := b.syncSet(table, SubnetNatSet, subnetsNeedNat, false)
+	if err != nil {
+		return gatewayBackendRuleError(b.Name(), "sync subnets-nat set", err)
+	}
+
+	postrouting := b.conn.AddChain(&nftables.Chain{
+		Name:     "postrouting",
+		Table:    table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookPostrouting,
+		Priority: nftables.ChainPriorityNATSource,
+	})
+	forward := b.conn.AddChain(&nftables.Chain{
+		Name:     "forward",
+		Table:    table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookForward,
+		Priority: nftables.ChainPriorityFilter,
+	})
+
+	b.conn.FlushChain(postrouting)
+	b.conn.FlushChain(forward)
+
+	family := nftablesFamily(protocol)
+	// Egress IP SNAT rules are appended to postrouting before the
+	// masquerade rules below, so they're evaluated first: a pod matched by
+	// an EgressIP gets SNATed to it instead of falling through to the
+	// blanket MASQUERADE rules.
+	if err := b.syncEgressIPRules(table, postrouting, family, egressIPRules); err != nil {
+		return gatewayBackendRuleError(b.Name(), "sync egress ip rules", err)
+	}
+	// podNatRule: local-pod-ip-nat src, ! subnets dst -> masquerade
+	b.addMasqueradeRule(postrouting, family, localPodSet, subnetsSet)
+	// subnetNatRule: subnets-nat src, ! subnets dst -> masquerade
+	b.addMasqueradeRule(postrouting, family, subnetsNatSet, subnetsSet)
+
+	// forwardAcceptRule1/2: accept traffic to/from the ovn0 interface
+	b.addForwardAcceptRules(forward)
+
+	if err := b.conn.Flush(); err != nil {
+		return gatewayBackendRuleError(b.Name(), "commit transaction", err)
+	}
+	klog.V(3).Infof("nftables gateway rules synced for protocol %s", protocol)
+	return nil
+}
+
+func nftablesFamily(protocol string) nftables.TableFamily {
+	if protocol == kubeovnv1.ProtocolIPv6 {
+		return nftables.TableFamilyIPv6
+	}
+	return nftables.TableFamilyIPv4
+}
+
+// syncSet programs an nft set of name under table with members, either as a
+// hash-of-addresses set (isHost) or as an interval set of CIDR ranges so a
+// member like 10.0.0.0/24 matches every address in the block.
+func (b *nftablesBackend) syncSet(table *nftables.Table, name string, members []string, isHost bool) (*nftables.Set, error) {
+	keyType := nftables.TypeIPAddr
+	if table.Family == nftables.TableFamilyIPv6 {
+		keyType = nftables.TypeIP6Addr
+	}
+	set := &nftables.Set{
+		Table:    table,
+		Name:     IPSetPrefix + name,
+		KeyType:  keyType,
+		Interval: !isHost,
+	}
+	if err := b.conn.AddSet(set, nil); err != nil {
+		return nil, err
+	}
+	// AddSet is a no-op on an existing set and SetAddElements only adds,
+	// so flush first to make every sync a full replace.
+	b.conn.FlushSet(set)
+
+	var elements []nftables.SetElement
+	for _, m := range members {
+		if _, ipNet, err := net.ParseCIDR(m); err == nil {
+			elements = append(elements, cidrRangeElements(ipNet)...)
+			continue
+		}
+		if ip := net.ParseIP(m); ip != nil {
+			if v4 := ip.To4(); v4 != nil && table.Family == nftables.TableFamilyIPv4 {
+				ip = v4
+			}
+			elements = append(elements, nftables.SetElement{Key: ip})
+			if !isHost {
+				elements = append(elements, nftables.SetElement{Key: nextIP(ip), IntervalEnd: true})
+			}
+		}
+	}
+	if err := b.conn.SetAddElements(set, elements); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// cidrRangeElements expresses ipNet as the [start, end) pair an nftables
+// interval set expects: the network address, and the address one past the
+// end of the block marked IntervalEnd so every address in between matches.
+func cidrRangeElements(ipNet *net.IPNet) []nftables.SetElement {
+	start := ipNet.IP.Mask(ipNet.Mask)
+	end := make(net.IP, len(start))
+	for i := range start {
+		end[i] = start[i] | ^ipNet.Mask[i]
+	}
+	return []nftables.SetElement{
+		{Key: start},
+		{Key: nextIP(end), IntervalEnd: true},
+	}
+}
+
+func nextIP(ip net.IP) net.IP {
+	out := make(net.IP, len(ip))
+	copy(out, ip)
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i]++
+		if out[i] != 0 {
+			break
+		}
+	}
+	return out
+}
+
+// syncEgressIPRules programs an nft set and SNAT rule for each egress IP in
+// podIPsByEgressIP, then deletes the set for any egress IP no longer active
+// on this node.
+func (b *nftablesBackend) syncEgressIPRules(table *nftables.Table, chain *nftables.Chain, family nftables.TableFamily, podIPsByEgressIP map[string][]string) error {
+	newSets := map[string]*nftables.Set{}
+	for egressIP, podIPs := range podIPsByEgressIP {
+		set, err := b.syncSet(table, egressIPSetName(egressIP), podIPs, true)
+		if err != nil {
+			return err
+		}
+		newSets[egressIP] = set
+		b.addEgressSNATRule(chain, family, set, egressIP)
+	}
+
+	for egressIP, set := range b.egressSets[family] {
+		if _, stillActive := newSets[egressIP]; stillActive {
+			continue
+		}
+		b.conn.DelSet(set)
+	}
+	if b.egressSets == nil {
+		b.egressSets = map[nftables.TableFamily]map[string]*nftables.Set{}
+	}
+	b.egressSets[family] = newSets
+	return nil
+}
+
+func egressIPSetName(egressIP string) string {
+	return EgressIPSet + "-" + util.EncodeIpsetName(egressIP)
+}
+
+// addEgressSNATRule matches packets whose source address is in podSet and
+// SNATs them to egressIP, appended ahead of the blanket MASQUERADE rules so
+// a pod matched by an EgressIP is SNATed to it instead of falling through.
+func (b *nftablesBackend) addEgressSNATRule(chain *nftables.Chain, family nftables.TableFamily, podSet *nftables.Set, egressIP string) {
+	srcOffset, _, length := addressPayloadOffsets(family)
+	ip := net.ParseIP(egressIP)
+	if family == nftables.TableFamilyIPv4 {
+		ip = ip.To4()
+	} else {
+		ip = ip.To16()
+	}
+	b.conn.AddRule(&nftables.Rule{
+		Table: chain.Table,
+		Chain: chain,
+		Exprs: []expr.Any{
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: srcOffset, Len: length},
+			&expr.Lookup{SetName: podSet.Name, SourceRegister: 1},
+			&expr.Immediate{Register: 1, Data: ip},
+			&expr.NAT{Type: expr.NATTypeSourceNAT, Family: uint32(family), RegAddrMin: 1},
+		},
+	})
+}
+
+// addMasqueradeRule matches packets whose source address is in src and
+// whose destination address is not in notDst, then masquerades them. The
+// source/dest addresses have to be loaded from the packet header into a
+// register before the set lookups below can test them; without that load
+// both Lookup exprs silently reuse whatever register 1 last held (here,
+// nothing), so the rule never actually matches real traffic.
+func (b *nftablesBackend) addMasqueradeRule(chain *nftables.Chain, family nftables.TableFamily, src, notDst *nftables.Set) {
+	srcOffset, dstOffset, length := addressPayloadOffsets(family)
+	b.conn.AddRule(&nftables.Rule{
+		Table: chain.Table,
+		Chain: chain,
+		Exprs: []expr.Any{
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: srcOffset, Len: length},
+			&expr.Lookup{SetName: src.Name, SourceRegister: 1},
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: dstOffset, Len: length},
+			&expr.Lookup{SetName: notDst.Name, SourceRegister: 1, Invert: true},
+			&expr.Masq{},
+		},
+	})
+}
+
+// addressPayloadOffsets returns the network-header byte offset of the
+// source address, the destination address, and their shared length, for
+// family (IPv4: src 12/dst 16, length 4; IPv6: src 8/dst 24, length 16).
+func addressPayloadOffsets(family nftables.TableFamily) (srcOffset, dstOffset, length uint32) {
+	if family == nftables.TableFamilyIPv6 {
+		return 8, 24, 16
+	}
+	return 12, 16, 4
+}
+
+func (b *nftablesBackend) addForwardAcceptRules(chain *nftables.Chain) {
+	b.conn.AddRule(&nftables.Rule{
+		Table: chain.Table,
+		Chain: chain,
+		Exprs: []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte("ovn0\x00")},
+			&expr.Verdict{Kind: expr.VerdictAccept},
+		},
+	})
+	b.conn.AddRule(&nftables.Rule{
+		Table: chain.Table,
+		Chain: chain,
+		Exprs: []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte("ovn0\x00")},
+			&expr.Ct{Key: expr.CtKeySTATE, Register: 1},
+			&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4,
+				Mask: binaryUint32(ctStateRelatedEstablished),
+				Xor:  binaryUint32(0)},
+			&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: binaryUint32(0)},
+			&expr.Verdict{Kind: expr.VerdictAccept},
+		},
+	})
+}
+
+func binaryUint32(v uint32) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+}
@@ -0,0 +1,109 @@
+package ipam
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+
+	"k8s.io/klog"
+)
+
+const (
+	AllocationPolicySequential = "Sequential"
+	AllocationPolicyDensePack  = "DensePack"
+	AllocationPolicyStickyHash = "StickyHash"
+)
+
+// AllocationStrategy picks the next address to hand out from a subnet's free
+// ranges. Implementations must not mutate freeList; the caller is
+// responsible for removing the returned address from it.
+type AllocationStrategy interface {
+	Name() string
+	Allocate(freeList IPRangeList, podName string) (IP, error)
+}
+
+// NewAllocationStrategy returns the strategy for the given policy name,
+// falling back to Sequential (the historical behavior) when policy is
+// empty or unrecognized.
+func NewAllocationStrategy(policy string) AllocationStrategy {
+	switch policy {
+	case AllocationPolicyDensePack:
+		return &DensePackStrategy{}
+	case AllocationPolicyStickyHash:
+		return &StickyHashStrategy{}
+	case AllocationPolicySequential, "":
+		return &SequentialStrategy{}
+	default:
+		klog.Warningf("unknown allocation policy %s, falling back to sequential", policy)
+		return &SequentialStrategy{}
+	}
+}
+
+// SequentialStrategy allocates the lowest free IP first, the behavior IPAM
+// has always had.
+type SequentialStrategy struct{}
+
+func (s *SequentialStrategy) Name() string { return AllocationPolicySequential }
+
+func (s *SequentialStrategy) Allocate(freeList IPRangeList, podName string) (IP, error) {
+	if len(freeList) == 0 {
+		return "", NoAvailableError
+	}
+	return freeList[0].Start, nil
+}
+
+// DensePackStrategy allocates from the smallest contiguous free range,
+// trading off even distribution for less fragmentation of the remaining
+// free ranges.
+type DensePackStrategy struct{}
+
+func (s *DensePackStrategy) Name() string { return AllocationPolicyDensePack }
+
+func (s *DensePackStrategy) Allocate(freeList IPRangeList, podName string) (IP, error) {
+	if len(freeList) == 0 {
+		return "", NoAvailableError
+	}
+	smallest := freeList[0]
+	for _, r := range freeList[1:] {
+		if r.Size().Cmp(smallest.Size()) < 0 {
+			smallest = r
+		}
+	}
+	return smallest.Start, nil
+}
+
+// StickyHashStrategy deterministically derives an offset into the free
+// range from the pod name (namespace/name/workload UID, whatever the caller
+// passes in as podName) so that a restarted pod tends to land back on the
+// same address within a subnet.
+type StickyHashStrategy struct{}
+
+func (s *StickyHashStrategy) Name() string { return AllocationPolicyStickyHash }
+
+func (s *StickyHashStrategy) Allocate(freeList IPRangeList, podName string) (IP, error) {
+	if len(freeList) == 0 {
+		return "", NoAvailableError
+	}
+
+	total := big.NewInt(0)
+	for _, r := range freeList {
+		total.Add(total, r.Size())
+	}
+	if total.Sign() == 0 {
+		return "", NoAvailableError
+	}
+
+	sum := sha256.Sum256([]byte(podName))
+	h := new(big.Int).SetUint64(binary.BigEndian.Uint64(sum[:8]))
+	offset := new(big.Int).Mod(h, total)
+
+	for _, r := range freeList {
+		size := r.Size()
+		if offset.Cmp(size) < 0 {
+			return r.Start.Add(offset), nil
+		}
+		offset.Sub(offset, size)
+	}
+	// Should be unreachable given the modulo above.
+	return freeList[0].Start, nil
+}
@@ -0,0 +1,57 @@
+package ipam
+
+import "testing"
+
+func TestSequentialStrategyAllocatesLowest(t *testing.T) {
+	freeList := IPRangeList{
+		{Start: "10.0.0.5", End: "10.0.0.10"},
+		{Start: "10.0.0.20", End: "10.0.0.20"},
+	}
+	ip, err := (&SequentialStrategy{}).Allocate(freeList, "pod1")
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if ip != "10.0.0.5" {
+		t.Fatalf("Allocate = %s, want 10.0.0.5", ip)
+	}
+}
+
+func TestDensePackStrategyAllocatesSmallestRange(t *testing.T) {
+	freeList := IPRangeList{
+		{Start: "10.0.0.0", End: "10.0.0.10"},
+		{Start: "10.0.0.20", End: "10.0.0.20"},
+	}
+	ip, err := (&DensePackStrategy{}).Allocate(freeList, "pod1")
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if ip != "10.0.0.20" {
+		t.Fatalf("Allocate = %s, want 10.0.0.20 (the single-address range)", ip)
+	}
+}
+
+func TestStickyHashStrategyIsDeterministic(t *testing.T) {
+	freeList := IPRangeList{
+		{Start: "10.0.0.0", End: "10.0.0.255"},
+	}
+	s := &StickyHashStrategy{}
+	first, err := s.Allocate(freeList, "pod1")
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	second, err := s.Allocate(freeList, "pod1")
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if first != second {
+		t.Fatalf("Allocate(pod1) = %s then %s, want the same address both times", first, second)
+	}
+}
+
+func TestAllocationStrategyEmptyFreeList(t *testing.T) {
+	for _, s := range []AllocationStrategy{&SequentialStrategy{}, &DensePackStrategy{}, &StickyHashStrategy{}} {
+		if _, err := s.Allocate(nil, "pod1"); err != NoAvailableError {
+			t.Errorf("%s.Allocate(nil, ...) = %v, want NoAvailableError", s.Name(), err)
+		}
+	}
+}
@@ -12,10 +12,11 @@ import (
 )
 
 var (
-	OutOfRangeError  = errors.New("AddressOutOfRange")
-	ConflictError    = errors.New("AddressConflict")
-	NoAvailableError = errors.New("NoAvailableAddress")
-	InvalidCIDRError = errors.New("CIDRInvalid")
+	OutOfRangeError      = errors.New("AddressOutOfRange")
+	ConflictError        = errors.New("AddressConflict")
+	NoAvailableError     = errors.New("NoAvailableAddress")
+	InvalidCIDRError     = errors.New("CIDRInvalid")
+	SnapshotVersionError = errors.New("SnapshotVersionMismatch")
 )
 
 type IPAM struct {
@@ -36,55 +37,80 @@ func NewIPAM() *IPAM {
 	}
 }
 
-func (ipam *IPAM) GetRandomAddress(podName string, subnetName string) (string, string, string, error) {
+// getSubnet takes only the short-lived map read lock and returns the
+// *Subnet pointer. Callers must not hold ipam.mutex while allocating or
+// releasing on the returned subnet; Subnet guards its own internal state
+// with its own mutex.
+func (ipam *IPAM) getSubnet(subnetName string) (*Subnet, bool) {
 	ipam.mutex.RLock()
 	defer ipam.mutex.RUnlock()
-	if subnet, ok := ipam.Subnets[subnetName]; !ok {
+	subnet, ok := ipam.Subnets[subnetName]
+	return subnet, ok
+}
+
+func (ipam *IPAM) GetRandomAddress(podName string, subnetName string) (string, string, string, error) {
+	subnet, ok := ipam.getSubnet(subnetName)
+	if !ok {
 		return "", "", "", NoAvailableError
-	} else {
-		v4IP, v6IP, mac, err := subnet.GetRandomAddress(podName)
-		klog.Infof("allocate v4 %s v6 %s mac %s for %s", v4IP, v6IP, mac, podName)
-		return string(v4IP), string(v6IP), mac, err
 	}
+	v4IP, v6IP, mac, err := subnet.GetRandomAddress(podName)
+	if err == NoAvailableError {
+		incNoAvailable()
+	} else if err == nil {
+		incAllocations()
+	}
+	klog.Infof("allocate v4 %s v6 %s mac %s for %s", v4IP, v6IP, mac, podName)
+	return string(v4IP), string(v6IP), mac, err
 }
 
 func (ipam *IPAM) GetStaticAddress(podName string, ip, mac string, subnetName string) (string, string, string, error) {
-	ipam.mutex.RLock()
-	defer ipam.mutex.RUnlock()
-	if subnet, ok := ipam.Subnets[subnetName]; !ok {
+	subnet, ok := ipam.getSubnet(subnetName)
+	if !ok {
 		return "", "", "", NoAvailableError
-	} else {
-		protocol := util.CheckProtocol(ip)
-		if protocol == kubeovnv1.ProtocolDual {
-			ips := strings.Split(ip, ",")
-			v4IP, mac, err := subnet.GetStaticAddress(podName, IP(ips[0]), mac, false)
-			v6IP, mac, err := subnet.GetStaticAddress(podName, IP(ips[1]), mac, false)
-			klog.Infof("allocate v4 %s v6 %s mac %s for pod %s", v4IP, v6IP, mac, podName)
-			return string(v4IP), string(v6IP), mac, err
-		} else {
-			ip, mac, err := subnet.GetStaticAddress(podName, IP(ip), mac, false)
-			klog.Infof("allocate %s %s for %s", ip, mac, podName)
-			if protocol == kubeovnv1.ProtocolIPv4 {
-				return string(ip), "", mac, err
-			} else {
-				return "", string(ip), mac, err
-			}
+	}
+	protocol := util.CheckProtocol(ip)
+	if protocol == kubeovnv1.ProtocolDual {
+		ips := strings.Split(ip, ",")
+		v4IP, mac, err := subnet.GetStaticAddress(podName, IP(ips[0]), mac, false)
+		v6IP, mac, err := subnet.GetStaticAddress(podName, IP(ips[1]), mac, false)
+		if err == NoAvailableError {
+			incNoAvailable()
+		} else if err == nil {
+			incAllocations()
 		}
+		klog.Infof("allocate v4 %s v6 %s mac %s for pod %s", v4IP, v6IP, mac, podName)
+		return string(v4IP), string(v6IP), mac, err
+	}
+	gotIP, mac, err := subnet.GetStaticAddress(podName, IP(ip), mac, false)
+	if err == NoAvailableError {
+		incNoAvailable()
+	} else if err == nil {
+		incAllocations()
 	}
+	klog.Infof("allocate %s %s for %s", gotIP, mac, podName)
+	if protocol == kubeovnv1.ProtocolIPv4 {
+		return string(gotIP), "", mac, err
+	}
+	return "", string(gotIP), mac, err
 }
 
 func (ipam *IPAM) ReleaseAddressByPod(podName string) {
 	ipam.mutex.RLock()
-	defer ipam.mutex.RUnlock()
+	subnets := make([]*Subnet, 0, len(ipam.Subnets))
 	for _, subnet := range ipam.Subnets {
+		subnets = append(subnets, subnet)
+	}
+	ipam.mutex.RUnlock()
+
+	for _, subnet := range subnets {
 		subnet.ReleaseAddress(podName)
 	}
-	return
+	incReleases()
 }
 
-func (ipam *IPAM) AddOrUpdateSubnet(name, cidrStr string, excludeIps []string) error {
-	ipam.mutex.Lock()
-	defer ipam.mutex.Unlock()
+// AddOrUpdateSubnet rebuilds an existing subnet under its own mutex rather
+// than ipam.mutex, so it doesn't block allocations against other subnets.
+func (ipam *IPAM) AddOrUpdateSubnet(name, cidrStr string, excludeIps []string, allocationPolicy string) error {
 	v4cidrStr := cidrStr
 	v6cidrStr := cidrStr
 	var err error
@@ -102,51 +128,71 @@ func (ipam *IPAM) AddOrUpdateSubnet(name, cidrStr string, excludeIps []string) e
 	// subnet.Spec.ExcludeIps contains both v4 and v6 addresses
 	v4ExcludeIps, v6ExcludeIps := splitIpsByProtocol(excludeIps)
 
-	if subnet, ok := ipam.Subnets[name]; ok {
-		subnet.Protocol = protocol
-		if protocol == kubeovnv1.ProtocolDual || protocol == kubeovnv1.ProtocolIPv4 {
-			subnet.V4ReservedIPList = convertExcludeIps(v4ExcludeIps)
-			firstIP, _ := util.FirstSubnetIP(v4cidrStr)
-			lastIP, _ := util.LastIP(v4cidrStr)
-			subnet.V4FreeIPList = IPRangeList{&IPRange{Start: IP(firstIP), End: IP(lastIP)}}
-			subnet.joinFreeWithReserve()
-			for podName, ip := range subnet.V4PodToIP {
-				mac := subnet.PodToMac[podName]
-				if _, _, err := subnet.GetStaticAddress(podName, ip, mac, true); err != nil {
-					klog.Errorf("%s address not in subnet %s new cidr %s", podName, name, cidrStr)
-				}
-			}
-		}
-		if protocol == kubeovnv1.ProtocolDual || protocol == kubeovnv1.ProtocolIPv6 {
-			subnet.V6ReservedIPList = convertExcludeIps(v6ExcludeIps)
-			firstIP, _ := util.FirstSubnetIP(v6cidrStr)
-			lastIP, _ := util.LastIP(v6cidrStr)
-			subnet.V6FreeIPList = IPRangeList{&IPRange{Start: IP(firstIP), End: IP(lastIP)}}
-			subnet.joinFreeWithReserve()
-			for podName, ip := range subnet.V6PodToIP {
-				mac := subnet.PodToMac[podName]
-				if _, _, err := subnet.GetStaticAddress(podName, ip, mac, true); err != nil {
-					klog.Errorf("%s address not in subnet %s new cidr %s", podName, name, cidrStr)
-				}
-			}
+	ipam.mutex.Lock()
+	subnet, ok := ipam.Subnets[name]
+	if !ok {
+		// Keep construction inside the same critical section as the
+		// lookup above so two callers can't race each other into
+		// creating two Subnets for the same name.
+		defer ipam.mutex.Unlock()
+		subnet, err := NewSubnet(name, cidrStr, excludeIps, allocationPolicy)
+		if err != nil {
+			return err
 		}
+		klog.Infof("adding new subnet %s", name)
+		ipam.Subnets[name] = subnet
+		incSubnetsTotal()
 		return nil
 	}
+	ipam.mutex.Unlock()
+
+	subnet.mutex.Lock()
+	subnet.Protocol = protocol
+	subnet.AllocationStrategy = NewAllocationStrategy(allocationPolicy)
+	var v4PodToIP, v6PodToIP map[string]IP
+	if protocol == kubeovnv1.ProtocolDual || protocol == kubeovnv1.ProtocolIPv4 {
+		subnet.V4ReservedIPList = convertExcludeIps(v4ExcludeIps)
+		firstIP, _ := util.FirstSubnetIP(v4cidrStr)
+		lastIP, _ := util.LastIP(v4cidrStr)
+		subnet.V4FreeIPList = IPRangeList{&IPRange{Start: IP(firstIP), End: IP(lastIP)}}
+		subnet.joinFreeWithReserve()
+		v4PodToIP = subnet.V4PodToIP
+	}
+	if protocol == kubeovnv1.ProtocolDual || protocol == kubeovnv1.ProtocolIPv6 {
+		subnet.V6ReservedIPList = convertExcludeIps(v6ExcludeIps)
+		firstIP, _ := util.FirstSubnetIP(v6cidrStr)
+		lastIP, _ := util.LastIP(v6cidrStr)
+		subnet.V6FreeIPList = IPRangeList{&IPRange{Start: IP(firstIP), End: IP(lastIP)}}
+		subnet.joinFreeWithReserve()
+		v6PodToIP = subnet.V6PodToIP
+	}
+	podToMac := subnet.PodToMac
+	subnet.mutex.Unlock()
 
-	subnet, err := NewSubnet(name, cidrStr, excludeIps)
-	if err != nil {
-		return err
+	for podName, ip := range v4PodToIP {
+		mac := podToMac[podName]
+		if _, _, err := subnet.GetStaticAddress(podName, ip, mac, true); err != nil {
+			klog.Errorf("%s address not in subnet %s new cidr %s", podName, name, cidrStr)
+		}
+	}
+	for podName, ip := range v6PodToIP {
+		mac := podToMac[podName]
+		if _, _, err := subnet.GetStaticAddress(podName, ip, mac, true); err != nil {
+			klog.Errorf("%s address not in subnet %s new cidr %s", podName, name, cidrStr)
+		}
 	}
-	klog.Infof("adding new subnet %s", name)
-	ipam.Subnets[name] = subnet
 	return nil
 }
 
 func (ipam *IPAM) DeleteSubnet(subnetName string) {
 	ipam.mutex.Lock()
 	defer ipam.mutex.Unlock()
+	if _, ok := ipam.Subnets[subnetName]; !ok {
+		return
+	}
 	klog.Infof("delete subnet %s", subnetName)
 	delete(ipam.Subnets, subnetName)
+	decSubnetsTotal()
 }
 
 func (ipam *IPAM) GetPodAddress(podName string) []*SubnetAddress {
@@ -0,0 +1,135 @@
+package ipam
+
+import (
+	"time"
+
+	"k8s.io/klog"
+)
+
+// defaultLeaseCheckInterval is how often the background reaper scans for
+// expired leases.
+const defaultLeaseCheckInterval = 10 * time.Second
+
+// Lease represents a temporarily reserved address held by owner (e.g. a
+// LoadBalancer VIP allocator or a bare-metal handoff controller) before a
+// pod exists to claim it via GetStaticAddress.
+type Lease struct {
+	IP        IP
+	Owner     string
+	ExpiresAt time.Time
+}
+
+func (l *Lease) expired(now time.Time) bool {
+	return now.After(l.ExpiresAt)
+}
+
+// ReserveAddress holds ip in subnetName for owner until ttl elapses. The
+// address is subtracted from the subnet's free list exactly like an entry
+// in ReservedIPList, so it will not be handed out by GetRandomAddress or
+// GetStaticAddress while the lease is outstanding.
+func (ipam *IPAM) ReserveAddress(subnetName string, ip IP, owner string, ttl time.Duration) error {
+	subnet, ok := ipam.getSubnet(subnetName)
+	if !ok {
+		return NoAvailableError
+	}
+	subnet.mutex.Lock()
+	defer subnet.mutex.Unlock()
+	if subnet.Leases == nil {
+		subnet.Leases = map[string]*Lease{}
+	}
+	if _, ok := subnet.Leases[owner]; ok {
+		return ConflictError
+	}
+	if !subnet.removeFromFreeList(ip) {
+		return OutOfRangeError
+	}
+	subnet.Leases[owner] = &Lease{IP: ip, Owner: owner, ExpiresAt: time.Now().Add(ttl)}
+	klog.Infof("reserved %s in subnet %s for %s, ttl %s", ip, subnetName, owner, ttl)
+	return nil
+}
+
+// RenewLease extends the TTL of the lease held by owner in subnetName.
+func (ipam *IPAM) RenewLease(subnetName string, owner string, ttl time.Duration) error {
+	subnet, ok := ipam.getSubnet(subnetName)
+	if !ok {
+		return NoAvailableError
+	}
+	subnet.mutex.Lock()
+	defer subnet.mutex.Unlock()
+	lease, ok := subnet.Leases[owner]
+	if !ok {
+		return NoAvailableError
+	}
+	lease.ExpiresAt = time.Now().Add(ttl)
+	return nil
+}
+
+// ReleaseLease releases a lease before it expires and returns its address
+// to the free list, e.g. so that the pod it was held for can then claim the
+// address via GetStaticAddress.
+func (ipam *IPAM) ReleaseLease(subnetName string, owner string) {
+	subnet, ok := ipam.getSubnet(subnetName)
+	if !ok {
+		return
+	}
+	subnet.mutex.Lock()
+	lease, ok := subnet.Leases[owner]
+	if ok {
+		// A lease's address was never recorded in V4PodToIP/V6PodToIP, so
+		// it has to go back to the free list directly.
+		subnet.addToFreeList(lease.IP)
+		delete(subnet.Leases, owner)
+	}
+	subnet.mutex.Unlock()
+	if !ok {
+		return
+	}
+	klog.Infof("released lease on %s in subnet %s held by %s", lease.IP, subnetName, owner)
+}
+
+// StartLeaseReaper launches a background goroutine that periodically
+// releases expired leases across all subnets. It runs until stopCh is
+// closed.
+func (ipam *IPAM) StartLeaseReaper(stopCh <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(defaultLeaseCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				ipam.reapExpiredLeases()
+			}
+		}
+	}()
+}
+
+func (ipam *IPAM) reapExpiredLeases() {
+	ipam.mutex.RLock()
+	subnets := make(map[string]*Subnet, len(ipam.Subnets))
+	for name, subnet := range ipam.Subnets {
+		subnets[name] = subnet
+	}
+	ipam.mutex.RUnlock()
+
+	now := time.Now()
+	for name, subnet := range subnets {
+		subnet.mutex.Lock()
+		var expired []*Lease
+		for _, lease := range subnet.Leases {
+			if lease.expired(now) {
+				expired = append(expired, lease)
+			}
+		}
+		for _, lease := range expired {
+			subnet.addToFreeList(lease.IP)
+			delete(subnet.Leases, lease.Owner)
+		}
+		subnet.mutex.Unlock()
+
+		for _, lease := range expired {
+			klog.Infof("lease for %s in subnet %s expired, releasing", lease.Owner, name)
+		}
+	}
+}
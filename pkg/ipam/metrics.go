@@ -0,0 +1,134 @@
+package ipam
+
+import (
+	"sync/atomic"
+
+	kubeovnv1 "github.com/alauda/kube-ovn/pkg/apis/kubeovn/v1"
+	"github.com/alauda/kube-ovn/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// counters are updated by AddOrUpdateSubnet, GetRandomAddress,
+// GetStaticAddress, ReleaseAddressByPod and DeleteSubnet so the collector
+// below can report allocation/release rates and the NoAvailableError rate
+// without taking the IPAM lock.
+var (
+	allocationsTotal uint64
+	releasesTotal    uint64
+	noAvailableTotal uint64
+	subnetsTotal     int64
+)
+
+func incAllocations() { atomic.AddUint64(&allocationsTotal, 1) }
+func incReleases()    { atomic.AddUint64(&releasesTotal, 1) }
+func incNoAvailable() { atomic.AddUint64(&noAvailableTotal, 1) }
+func incSubnetsTotal() { atomic.AddInt64(&subnetsTotal, 1) }
+func decSubnetsTotal() { atomic.AddInt64(&subnetsTotal, -1) }
+
+var (
+	subnetAddressDesc = prometheus.NewDesc(
+		"kube_ovn_ipam_subnet_addresses",
+		"Number of addresses in a subnet by state (total, used, free, reserved, leased).",
+		[]string{"subnet", "protocol", "state"}, nil,
+	)
+	subnetFragmentationDesc = prometheus.NewDesc(
+		"kube_ovn_ipam_subnet_free_list_fragments",
+		"Number of disjoint ranges in a subnet's free IP list.",
+		[]string{"subnet", "protocol"}, nil,
+	)
+	subnetLeasesDesc = prometheus.NewDesc(
+		"kube_ovn_ipam_subnet_leases",
+		"Number of outstanding leases held against a subnet, including expired leases the reaper has not yet released.",
+		[]string{"subnet"}, nil,
+	)
+	allocationsTotalDesc = prometheus.NewDesc(
+		"kube_ovn_ipam_allocations_total",
+		"Total number of successful allocation requests handled by the IPAM allocator (one per request, whether it assigned one address or a dual-stack pair).",
+		nil, nil,
+	)
+	releasesTotalDesc = prometheus.NewDesc(
+		"kube_ovn_ipam_releases_total",
+		"Total number of pod release requests handled by the IPAM allocator (one per request, whether or not the pod held an address).",
+		nil, nil,
+	)
+	noAvailableTotalDesc = prometheus.NewDesc(
+		"kube_ovn_ipam_no_available_address_total",
+		"Total number of allocation attempts that failed with NoAvailableError.",
+		nil, nil,
+	)
+	subnetsTotalDesc = prometheus.NewDesc(
+		"kube_ovn_ipam_subnets_total",
+		"Total number of subnets currently managed by the IPAM allocator.",
+		nil, nil,
+	)
+)
+
+// Collector returns a prometheus.Collector exposing per-subnet address
+// accounting and allocator-wide counters. Register it once at process
+// startup, e.g. prometheus.MustRegister(ipam.Collector()).
+func (ipam *IPAM) Collector() prometheus.Collector {
+	return &ipamCollector{ipam: ipam}
+}
+
+type ipamCollector struct {
+	ipam *IPAM
+}
+
+func (c *ipamCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- subnetAddressDesc
+	ch <- subnetFragmentationDesc
+	ch <- subnetLeasesDesc
+	ch <- allocationsTotalDesc
+	ch <- releasesTotalDesc
+	ch <- noAvailableTotalDesc
+	ch <- subnetsTotalDesc
+}
+
+func (c *ipamCollector) Collect(ch chan<- prometheus.Metric) {
+	c.ipam.mutex.RLock()
+	subnets := make(map[string]*Subnet, len(c.ipam.Subnets))
+	for name, subnet := range c.ipam.Subnets {
+		subnets[name] = subnet
+	}
+	c.ipam.mutex.RUnlock()
+
+	for name, subnet := range subnets {
+		subnet.mutex.Lock()
+		v4Free, v4Reserved, v4Used := subnet.V4FreeIPList, subnet.V4ReservedIPList, len(subnet.V4PodToIP)
+		v6Free, v6Reserved, v6Used := subnet.V6FreeIPList, subnet.V6ReservedIPList, len(subnet.V6PodToIP)
+		leases := len(subnet.Leases)
+		var v4Leased, v6Leased int
+		for _, lease := range subnet.Leases {
+			if util.CheckProtocol(string(lease.IP)) == kubeovnv1.ProtocolIPv6 {
+				v6Leased++
+			} else {
+				v4Leased++
+			}
+		}
+		subnet.mutex.Unlock()
+
+		c.collectProtocol(ch, name, "ipv4", v4Free, v4Reserved, v4Used, v4Leased)
+		c.collectProtocol(ch, name, "ipv6", v6Free, v6Reserved, v6Used, v6Leased)
+		ch <- prometheus.MustNewConstMetric(subnetLeasesDesc, prometheus.GaugeValue, float64(leases), name)
+	}
+
+	ch <- prometheus.MustNewConstMetric(allocationsTotalDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&allocationsTotal)))
+	ch <- prometheus.MustNewConstMetric(releasesTotalDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&releasesTotal)))
+	ch <- prometheus.MustNewConstMetric(noAvailableTotalDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&noAvailableTotal)))
+	ch <- prometheus.MustNewConstMetric(subnetsTotalDesc, prometheus.GaugeValue, float64(atomic.LoadInt64(&subnetsTotal)))
+}
+
+func (c *ipamCollector) collectProtocol(ch chan<- prometheus.Metric, subnet, protocol string, free, reserved IPRangeList, used, leased int) {
+	freeCount := free.Count()
+	reservedCount := reserved.Count()
+	// ReserveAddress already removed leased addresses from the free list,
+	// so they have to be added back in here to keep total accurate.
+	total := freeCount + reservedCount + used + leased
+
+	ch <- prometheus.MustNewConstMetric(subnetAddressDesc, prometheus.GaugeValue, float64(total), subnet, protocol, "total")
+	ch <- prometheus.MustNewConstMetric(subnetAddressDesc, prometheus.GaugeValue, float64(used), subnet, protocol, "used")
+	ch <- prometheus.MustNewConstMetric(subnetAddressDesc, prometheus.GaugeValue, float64(freeCount), subnet, protocol, "free")
+	ch <- prometheus.MustNewConstMetric(subnetAddressDesc, prometheus.GaugeValue, float64(reservedCount), subnet, protocol, "reserved")
+	ch <- prometheus.MustNewConstMetric(subnetAddressDesc, prometheus.GaugeValue, float64(leased), subnet, protocol, "leased")
+	ch <- prometheus.MustNewConstMetric(subnetFragmentationDesc, prometheus.GaugeValue, float64(len(free)), subnet, protocol)
+}
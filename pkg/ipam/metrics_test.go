@@ -0,0 +1,73 @@
+package ipam
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// collectSubnetAddressMetrics drains the ipamCollector and returns the
+// kube_ovn_ipam_subnet_addresses gauge values for protocol, keyed by state.
+func collectSubnetAddressMetrics(t *testing.T, ipam *IPAM, subnet, protocol string) map[string]float64 {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 64)
+	go func() {
+		ipam.Collector().(*ipamCollector).Collect(ch)
+		close(ch)
+	}()
+
+	states := map[string]float64{}
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		var gotSubnet, gotProtocol, gotState string
+		for _, l := range pb.GetLabel() {
+			switch l.GetName() {
+			case "subnet":
+				gotSubnet = l.GetValue()
+			case "protocol":
+				gotProtocol = l.GetValue()
+			case "state":
+				gotState = l.GetValue()
+			}
+		}
+		if gotSubnet == subnet && gotProtocol == protocol {
+			states[gotState] = pb.GetGauge().GetValue()
+		}
+	}
+	return states
+}
+
+// TestSubnetAddressTotalIncludesLeases guards against outstanding leases
+// being subtracted from the free list (see removeFromFreeList, called from
+// ReserveAddress) without being folded back into the published total: a
+// leased address is neither free, reserved nor used, so total has to add
+// it back explicitly or it silently undercounts the subnet.
+func TestSubnetAddressTotalIncludesLeases(t *testing.T) {
+	ipam := NewIPAM()
+	if err := ipam.AddOrUpdateSubnet("subnet1", "10.0.0.0/30", nil, AllocationPolicySequential); err != nil {
+		t.Fatalf("AddOrUpdateSubnet: %v", err)
+	}
+
+	before := collectSubnetAddressMetrics(t, ipam, "subnet1", "ipv4")
+
+	if err := ipam.ReserveAddress("subnet1", IP("10.0.0.1"), "owner1", time.Minute); err != nil {
+		t.Fatalf("ReserveAddress: %v", err)
+	}
+
+	after := collectSubnetAddressMetrics(t, ipam, "subnet1", "ipv4")
+
+	if after["leased"] != 1 {
+		t.Fatalf("leased = %v, want 1", after["leased"])
+	}
+	if after["total"] != before["total"] {
+		t.Fatalf("total changed from %v to %v after reserving an address; reserving must not change the address count, only which state it's in", before["total"], after["total"])
+	}
+	if after["free"] != before["free"]-1 {
+		t.Fatalf("free = %v, want %v", after["free"], before["free"]-1)
+	}
+}
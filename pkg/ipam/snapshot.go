@@ -0,0 +1,103 @@
+package ipam
+
+import "encoding/json"
+
+// snapshotVersion is bumped whenever the snapshot wire format changes so
+// Restore can reject snapshots it no longer understands.
+const snapshotVersion = 1
+
+type subnetSnapshot struct {
+	Name             string            `json:"name"`
+	Protocol         string            `json:"protocol"`
+	V4FreeIPList     IPRangeList       `json:"v4FreeIpList,omitempty"`
+	V6FreeIPList     IPRangeList       `json:"v6FreeIpList,omitempty"`
+	V4ReservedIPList IPRangeList       `json:"v4ReservedIpList,omitempty"`
+	V6ReservedIPList IPRangeList       `json:"v6ReservedIpList,omitempty"`
+	V4PodToIP        map[string]IP     `json:"v4PodToIp,omitempty"`
+	V6PodToIP        map[string]IP     `json:"v6PodToIp,omitempty"`
+	PodToMac         map[string]string `json:"podToMac,omitempty"`
+	Leases           map[string]*Lease `json:"leases,omitempty"`
+}
+
+type ipamSnapshot struct {
+	Version int               `json:"version"`
+	Subnets []*subnetSnapshot `json:"subnets"`
+}
+
+// Snapshot serializes the free/reserved ranges, pod-to-address maps and
+// outstanding leases of every subnet so the controller can persist
+// allocator state (e.g. to a ConfigMap) and rebuild it on restart without
+// re-listing every pod.
+func (ipam *IPAM) Snapshot() ([]byte, error) {
+	ipam.mutex.RLock()
+	subnets := make(map[string]*Subnet, len(ipam.Subnets))
+	for name, subnet := range ipam.Subnets {
+		subnets[name] = subnet
+	}
+	ipam.mutex.RUnlock()
+
+	snap := &ipamSnapshot{Version: snapshotVersion}
+	for name, subnet := range subnets {
+		subnet.mutex.Lock()
+		snap.Subnets = append(snap.Subnets, &subnetSnapshot{
+			Name:             name,
+			Protocol:         subnet.Protocol,
+			V4FreeIPList:     subnet.V4FreeIPList,
+			V6FreeIPList:     subnet.V6FreeIPList,
+			V4ReservedIPList: subnet.V4ReservedIPList,
+			V6ReservedIPList: subnet.V6ReservedIPList,
+			V4PodToIP:        subnet.V4PodToIP,
+			V6PodToIP:        subnet.V6PodToIP,
+			PodToMac:         subnet.PodToMac,
+			Leases:           subnet.Leases,
+		})
+		subnet.mutex.Unlock()
+	}
+	return json.Marshal(snap)
+}
+
+// Restore rebuilds allocator state from a byte slice produced by Snapshot.
+// Subnets not present in the snapshot are left untouched; subnets in the
+// snapshot that no longer exist in ipam.Subnets are skipped, since the
+// corresponding Subnet CR is expected to recreate them via
+// AddOrUpdateSubnet first.
+func (ipam *IPAM) Restore(data []byte) error {
+	var snap ipamSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	if snap.Version != snapshotVersion {
+		return SnapshotVersionError
+	}
+
+	for _, s := range snap.Subnets {
+		subnet, ok := ipam.getSubnet(s.Name)
+		if !ok {
+			continue
+		}
+		subnet.mutex.Lock()
+		subnet.Protocol = s.Protocol
+		subnet.V4FreeIPList = s.V4FreeIPList
+		subnet.V6FreeIPList = s.V6FreeIPList
+		subnet.V4ReservedIPList = s.V4ReservedIPList
+		subnet.V6ReservedIPList = s.V6ReservedIPList
+		subnet.V4PodToIP = s.V4PodToIP
+		if subnet.V4PodToIP == nil {
+			subnet.V4PodToIP = map[string]IP{}
+		}
+		subnet.V6PodToIP = s.V6PodToIP
+		if subnet.V6PodToIP == nil {
+			subnet.V6PodToIP = map[string]IP{}
+		}
+		subnet.PodToMac = s.PodToMac
+		if subnet.PodToMac == nil {
+			subnet.PodToMac = map[string]string{}
+		}
+		subnet.Leases = s.Leases
+		if subnet.Leases == nil {
+			subnet.Leases = map[string]*Lease{}
+		}
+		subnet.mutex.Unlock()
+	}
+	return nil
+}
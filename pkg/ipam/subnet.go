@@ -0,0 +1,417 @@
+package ipam
+
+import (
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+
+	kubeovnv1 "github.com/alauda/kube-ovn/pkg/apis/kubeovn/v1"
+	"github.com/alauda/kube-ovn/pkg/util"
+)
+
+// IP is a textual IPv4 or IPv6 address used as the key type throughout the
+// allocator; arithmetic on it goes through big.Int so v4 and v6 share the
+// same range/offset machinery.
+type IP string
+
+// IPRange is an inclusive [Start, End] run of addresses of the same family.
+type IPRange struct {
+	Start IP `json:"start"`
+	End   IP `json:"end"`
+}
+
+// IPRangeList is a set of disjoint, ascending IPRanges.
+type IPRangeList []*IPRange
+
+// Size returns the number of addresses covered by r.
+func (r *IPRange) Size() *big.Int {
+	size := new(big.Int).Sub(ipToBigInt(r.End), ipToBigInt(r.Start))
+	return size.Add(size, big.NewInt(1))
+}
+
+// Count returns the total number of addresses across every range in l.
+func (l IPRangeList) Count() int {
+	total := big.NewInt(0)
+	for _, r := range l {
+		total.Add(total, r.Size())
+	}
+	return int(total.Int64())
+}
+
+func (ip IP) isV6() bool { return strings.Contains(string(ip), ":") }
+
+// Add returns the address offset positions after ip.
+func (ip IP) Add(offset *big.Int) IP {
+	return bigIntToIP(new(big.Int).Add(ipToBigInt(ip), offset), ip.isV6())
+}
+
+// Sub returns the address offset positions before ip.
+func (ip IP) Sub(offset *big.Int) IP {
+	return bigIntToIP(new(big.Int).Sub(ipToBigInt(ip), offset), ip.isV6())
+}
+
+// Cmp orders ip and other the way big.Int.Cmp orders integers.
+func (ip IP) Cmp(other IP) int {
+	return ipToBigInt(ip).Cmp(ipToBigInt(other))
+}
+
+func ipToBigInt(ip IP) *big.Int {
+	parsed := net.ParseIP(string(ip))
+	if parsed == nil {
+		return big.NewInt(0)
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return new(big.Int).SetBytes(v4)
+	}
+	return new(big.Int).SetBytes(parsed.To16())
+}
+
+func bigIntToIP(i *big.Int, v6 bool) IP {
+	width := net.IPv4len
+	if v6 {
+		width = net.IPv6len
+	}
+	buf := make([]byte, width)
+	b := i.Bytes()
+	if len(b) > width {
+		b = b[len(b)-width:]
+	}
+	copy(buf[width-len(b):], b)
+	return IP(net.IP(buf).String())
+}
+
+// removeIPFromList excises ip from list, splitting the range that contains
+// it as needed. It reports whether ip was found.
+func removeIPFromList(list IPRangeList, ip IP) (IPRangeList, bool) {
+	for i, r := range list {
+		if ip.Cmp(r.Start) < 0 || ip.Cmp(r.End) > 0 {
+			continue
+		}
+		out := make(IPRangeList, 0, len(list)+1)
+		out = append(out, list[:i]...)
+		if ip.Cmp(r.Start) > 0 {
+			out = append(out, &IPRange{Start: r.Start, End: ip.Sub(big.NewInt(1))})
+		}
+		if ip.Cmp(r.End) < 0 {
+			out = append(out, &IPRange{Start: ip.Add(big.NewInt(1)), End: r.End})
+		}
+		out = append(out, list[i+1:]...)
+		return out, true
+	}
+	return list, false
+}
+
+// addIPToList inserts ip into list in sorted order, merging it into an
+// adjacent range instead of creating a new one-address range where
+// possible.
+func addIPToList(list IPRangeList, ip IP) IPRangeList {
+	one := big.NewInt(1)
+	for i, r := range list {
+		if ip.Add(one).Cmp(r.Start) == 0 {
+			r.Start = ip
+			if i > 0 && list[i-1].End.Add(one).Cmp(r.Start) == 0 {
+				list[i-1].End = r.End
+				return append(list[:i], list[i+1:]...)
+			}
+			return list
+		}
+		if r.End.Add(one).Cmp(ip) == 0 {
+			r.End = ip
+			if i+1 < len(list) && r.End.Add(one).Cmp(list[i+1].Start) == 0 {
+				r.End = list[i+1].End
+				return append(list[:i+1], list[i+2:]...)
+			}
+			return list
+		}
+		if ip.Cmp(r.Start) < 0 {
+			out := make(IPRangeList, 0, len(list)+1)
+			out = append(out, list[:i]...)
+			out = append(out, &IPRange{Start: ip, End: ip})
+			out = append(out, list[i:]...)
+			return out
+		}
+	}
+	return append(list, &IPRange{Start: ip, End: ip})
+}
+
+// subtractRange removes sub from every range in list, splitting ranges
+// that straddle it.
+func subtractRange(list IPRangeList, sub *IPRange) IPRangeList {
+	var out IPRangeList
+	for _, r := range list {
+		if sub.End.Cmp(r.Start) < 0 || sub.Start.Cmp(r.End) > 0 {
+			out = append(out, r)
+			continue
+		}
+		if sub.Start.Cmp(r.Start) > 0 {
+			out = append(out, &IPRange{Start: r.Start, End: sub.Start.Sub(big.NewInt(1))})
+		}
+		if sub.End.Cmp(r.End) < 0 {
+			out = append(out, &IPRange{Start: sub.End.Add(big.NewInt(1)), End: r.End})
+		}
+	}
+	return out
+}
+
+func subtractRangeList(base, sub IPRangeList) IPRangeList {
+	for _, r := range sub {
+		base = subtractRange(base, r)
+	}
+	return base
+}
+
+func splitIpsByProtocol(excludeIps []string) (v4, v6 []string) {
+	for _, ip := range excludeIps {
+		if strings.Contains(ip, ":") {
+			v6 = append(v6, ip)
+		} else {
+			v4 = append(v4, ip)
+		}
+	}
+	return v4, v6
+}
+
+// convertExcludeIps turns the Subnet.Spec.ExcludeIps strings (either a bare
+// address or a "start..end" range) into an IPRangeList suitable for
+// V4ReservedIPList/V6ReservedIPList.
+func convertExcludeIps(excludeIps []string) IPRangeList {
+	var list IPRangeList
+	for _, raw := range excludeIps {
+		if parts := strings.Split(raw, ".."); len(parts) == 2 {
+			list = append(list, &IPRange{Start: IP(parts[0]), End: IP(parts[1])})
+			continue
+		}
+		list = append(list, &IPRange{Start: IP(raw), End: IP(raw)})
+	}
+	return list
+}
+
+// Subnet tracks the free/reserved ranges and pod/lease ownership of a
+// single logical switch's address pool. All of its fields must only be
+// touched while holding mutex; the Subnet methods take it themselves, and
+// IPAM code that reads or writes the fields directly takes it first.
+type Subnet struct {
+	Name     string
+	Protocol string
+
+	mutex sync.Mutex
+
+	V4ReservedIPList IPRangeList
+	V6ReservedIPList IPRangeList
+	V4FreeIPList     IPRangeList
+	V6FreeIPList     IPRangeList
+
+	V4PodToIP map[string]IP
+	V6PodToIP map[string]IP
+	PodToMac  map[string]string
+
+	Leases map[string]*Lease
+
+	// AllocationStrategy picks the address GetRandomAddress hands out
+	// from the free list(s); set by AddOrUpdateSubnet from the Subnet
+	// CR's allocation policy.
+	AllocationStrategy AllocationStrategy
+}
+
+// NewSubnet builds a Subnet for a newly created Subnet CR, computing its
+// free list as the full CIDR range minus excludeIps.
+func NewSubnet(name, cidrStr string, excludeIps []string, allocationPolicy string) (*Subnet, error) {
+	v4cidrStr := cidrStr
+	v6cidrStr := cidrStr
+	var err error
+
+	protocol := util.CheckProtocol(cidrStr)
+	if protocol == kubeovnv1.ProtocolDual {
+		v4cidrStr, v6cidrStr, err = util.CheckDualCidrs(cidrStr)
+	} else {
+		_, _, err = net.ParseCIDR(cidrStr)
+	}
+	if err != nil {
+		return nil, InvalidCIDRError
+	}
+
+	v4ExcludeIps, v6ExcludeIps := splitIpsByProtocol(excludeIps)
+	subnet := &Subnet{
+		Name:               name,
+		Protocol:           protocol,
+		V4PodToIP:          map[string]IP{},
+		V6PodToIP:          map[string]IP{},
+		PodToMac:           map[string]string{},
+		Leases:             map[string]*Lease{},
+		AllocationStrategy: NewAllocationStrategy(allocationPolicy),
+	}
+
+	if protocol == kubeovnv1.ProtocolDual || protocol == kubeovnv1.ProtocolIPv4 {
+		subnet.V4ReservedIPList = convertExcludeIps(v4ExcludeIps)
+		firstIP, _ := util.FirstSubnetIP(v4cidrStr)
+		lastIP, _ := util.LastIP(v4cidrStr)
+		subnet.V4FreeIPList = IPRangeList{&IPRange{Start: IP(firstIP), End: IP(lastIP)}}
+		subnet.joinFreeWithReserve()
+	}
+	if protocol == kubeovnv1.ProtocolDual || protocol == kubeovnv1.ProtocolIPv6 {
+		subnet.V6ReservedIPList = convertExcludeIps(v6ExcludeIps)
+		firstIP, _ := util.FirstSubnetIP(v6cidrStr)
+		lastIP, _ := util.LastIP(v6cidrStr)
+		subnet.V6FreeIPList = IPRangeList{&IPRange{Start: IP(firstIP), End: IP(lastIP)}}
+		subnet.joinFreeWithReserve()
+	}
+	return subnet, nil
+}
+
+// joinFreeWithReserve recomputes the free lists from scratch by subtracting
+// the reserved ranges and every address already handed out (to a pod or a
+// lease) from the newly-set V4FreeIPList/V6FreeIPList. Callers must already
+// hold subnet.mutex.
+func (subnet *Subnet) joinFreeWithReserve() {
+	subnet.V4FreeIPList = subtractRangeList(subnet.V4FreeIPList, subnet.V4ReservedIPList)
+	subnet.V6FreeIPList = subtractRangeList(subnet.V6FreeIPList, subnet.V6ReservedIPList)
+	for _, ip := range subnet.V4PodToIP {
+		subnet.V4FreeIPList, _ = removeIPFromList(subnet.V4FreeIPList, ip)
+	}
+	for _, ip := range subnet.V6PodToIP {
+		subnet.V6FreeIPList, _ = removeIPFromList(subnet.V6FreeIPList, ip)
+	}
+	for _, lease := range subnet.Leases {
+		if lease.IP.isV6() {
+			subnet.V6FreeIPList, _ = removeIPFromList(subnet.V6FreeIPList, lease.IP)
+		} else {
+			subnet.V4FreeIPList, _ = removeIPFromList(subnet.V4FreeIPList, lease.IP)
+		}
+	}
+}
+
+// removeFromFreeList excises ip from whichever free list matches its
+// family. Caller holds subnet.mutex.
+func (subnet *Subnet) removeFromFreeList(ip IP) bool {
+	if ip.isV6() {
+		list, ok := removeIPFromList(subnet.V6FreeIPList, ip)
+		if ok {
+			subnet.V6FreeIPList = list
+		}
+		return ok
+	}
+	list, ok := removeIPFromList(subnet.V4FreeIPList, ip)
+	if ok {
+		subnet.V4FreeIPList = list
+	}
+	return ok
+}
+
+// addToFreeList is the counterpart to removeFromFreeList. Caller holds
+// subnet.mutex.
+func (subnet *Subnet) addToFreeList(ip IP) {
+	if ip.isV6() {
+		subnet.V6FreeIPList = addIPToList(subnet.V6FreeIPList, ip)
+		return
+	}
+	subnet.V4FreeIPList = addIPToList(subnet.V4FreeIPList, ip)
+}
+
+// GetRandomAddress allocates the next address for podName out of the
+// subnet's free list(s), picked via subnet.AllocationStrategy so that
+// DensePack/StickyHash policies actually influence which address is
+// returned instead of GetRandomAddress always taking the lowest free IP.
+func (subnet *Subnet) GetRandomAddress(podName string) (IP, IP, string, error) {
+	subnet.mutex.Lock()
+	defer subnet.mutex.Unlock()
+
+	strategy := subnet.AllocationStrategy
+	if strategy == nil {
+		strategy = NewAllocationStrategy("")
+	}
+
+	var v4IP, v6IP IP
+	var err error
+	if subnet.Protocol == kubeovnv1.ProtocolDual || subnet.Protocol == kubeovnv1.ProtocolIPv4 {
+		if v4IP, err = strategy.Allocate(subnet.V4FreeIPList, podName); err != nil {
+			return "", "", "", err
+		}
+	}
+	if subnet.Protocol == kubeovnv1.ProtocolDual || subnet.Protocol == kubeovnv1.ProtocolIPv6 {
+		if v6IP, err = strategy.Allocate(subnet.V6FreeIPList, podName); err != nil {
+			return "", "", "", err
+		}
+	}
+
+	mac := util.GenerateMac()
+	if v4IP != "" {
+		subnet.removeFromFreeList(v4IP)
+		subnet.V4PodToIP[podName] = v4IP
+	}
+	if v6IP != "" {
+		subnet.removeFromFreeList(v6IP)
+		subnet.V6PodToIP[podName] = v6IP
+	}
+	subnet.PodToMac[podName] = mac
+	return v4IP, v6IP, mac, nil
+}
+
+// GetStaticAddress assigns a caller-chosen ip to podName. When force is
+// true, ip is assumed to already be accounted for (e.g. reapplying an
+// existing pod's address after AddOrUpdateSubnet rebuilt the free list) and
+// is not removed from the free list again.
+func (subnet *Subnet) GetStaticAddress(podName string, ip IP, mac string, force bool) (IP, string, error) {
+	subnet.mutex.Lock()
+	defer subnet.mutex.Unlock()
+
+	if !force {
+		if !subnet.removeFromFreeList(ip) {
+			return "", "", OutOfRangeError
+		}
+	}
+	if mac == "" {
+		mac = util.GenerateMac()
+	}
+	if ip.isV6() {
+		subnet.V6PodToIP[podName] = ip
+	} else {
+		subnet.V4PodToIP[podName] = ip
+	}
+	subnet.PodToMac[podName] = mac
+	return ip, mac, nil
+}
+
+// ReleaseAddress returns every address held by podName to the free list.
+func (subnet *Subnet) ReleaseAddress(podName string) {
+	subnet.mutex.Lock()
+	defer subnet.mutex.Unlock()
+
+	if ip, ok := subnet.V4PodToIP[podName]; ok {
+		subnet.addToFreeList(ip)
+		delete(subnet.V4PodToIP, podName)
+	}
+	if ip, ok := subnet.V6PodToIP[podName]; ok {
+		subnet.addToFreeList(ip)
+		delete(subnet.V6PodToIP, podName)
+	}
+	delete(subnet.PodToMac, podName)
+}
+
+// ContainAddress reports whether ip is currently assigned to a pod in this
+// subnet.
+func (subnet *Subnet) ContainAddress(ip IP) bool {
+	subnet.mutex.Lock()
+	defer subnet.mutex.Unlock()
+
+	for _, podIP := range subnet.V4PodToIP {
+		if podIP == ip {
+			return true
+		}
+	}
+	for _, podIP := range subnet.V6PodToIP {
+		if podIP == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPodAddress returns the v4/v6 addresses and mac currently held by
+// podName, along with the subnet's protocol.
+func (subnet *Subnet) GetPodAddress(podName string) (IP, IP, string, string) {
+	subnet.mutex.Lock()
+	defer subnet.mutex.Unlock()
+
+	return subnet.V4PodToIP[podName], subnet.V6PodToIP[podName], subnet.PodToMac[podName], subnet.Protocol
+}